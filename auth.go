@@ -0,0 +1,193 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator decides whether an incoming HTTP request may reach the
+// bridge's wrapped handler. Authenticate returns nil to allow the request
+// through, or an error to reject it with 403 Forbidden.
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// authHandler wraps handler with auth, rejecting any request Authenticate
+// errors on. A nil auth lets every request through (see NewWithAuthenticator).
+func authHandler(auth Authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth != nil {
+			if err := auth.Authenticate(r); err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// StaticBearer is the default Authenticator, preserving the bridge's
+// original behavior: it requires an `Authorization: Bearer <token>` header
+// matching a single, fixed token.
+type StaticBearer struct {
+	Token string
+}
+
+// Authenticate implements Authenticator.
+func (s StaticBearer) Authenticate(r *http.Request) error {
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		return errors.New("ghostbridge: missing or invalid bearer token")
+	}
+	return nil
+}
+
+// NewWithAuthenticator is like New, but replaces the hard-coded bearer
+// comparison with a caller-supplied Authenticator, enabling per-route
+// scopes, rotating tokens (see RotatingBearer) or HMAC-signed requests (see
+// HMACRequest) without forking the package. A nil auth disables
+// authorization entirely.
+//
+// The bridge it returns is backed by a single self-signed leaf certificate,
+// same as NewWithIdentity(handler, nil); use that constructor instead if the
+// identity also needs to be persisted across restarts.
+func NewWithAuthenticator(handler http.Handler, auth Authenticator) (*Bridge, error) {
+	identity, err := generateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, authHandler(auth, handler))
+
+	return &Bridge{
+		identity: *identity,
+		listener: listener,
+	}, nil
+}
+
+// RotatingBearer authenticates requests against a set of active bearer
+// tokens instead of a single fixed one. Rotate introduces a new token while
+// keeping previously active ones valid for grace, so in-flight clients that
+// haven't picked up the new token yet aren't locked out mid-rotation.
+type RotatingBearer struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]time.Time // token -> the time it stops being accepted, zero if not yet rotated away
+}
+
+// NewRotatingBearer creates a RotatingBearer seeded with a single active
+// token and the grace window applied to tokens superseded by Rotate.
+func NewRotatingBearer(token string, grace time.Duration) *RotatingBearer {
+	return &RotatingBearer{
+		grace:  grace,
+		tokens: map[string]time.Time{token: {}},
+	}
+}
+
+// Rotate introduces token as the new active one. Every previously active
+// token that hasn't already been scheduled to expire is given until grace
+// from now before it stops being accepted.
+func (r *RotatingBearer) Rotate(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deadline := time.Now().Add(r.grace)
+	for old, expiry := range r.tokens {
+		if expiry.IsZero() {
+			r.tokens[old] = deadline
+		}
+	}
+	r.tokens[token] = time.Time{}
+}
+
+// Authenticate implements Authenticator.
+func (r *RotatingBearer) Authenticate(req *http.Request) error {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return errors.New("ghostbridge: missing bearer token")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiry, ok := r.tokens[token]
+	if !ok {
+		return errors.New("ghostbridge: invalid bearer token")
+	}
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		delete(r.tokens, token)
+		return errors.New("ghostbridge: bearer token rotated out")
+	}
+	return nil
+}
+
+// defaultHMACSkew is the allowed clock skew used by HMACRequest when Skew is
+// left at its zero value.
+const defaultHMACSkew = 30 * time.Second
+
+// HMACRequest authenticates requests signed with a shared secret: the
+// caller computes an HMAC-SHA256 over the request method, path, body and an
+// X-Bridge-Timestamp header (unix seconds), hex-encodes it and sends it as
+// X-Bridge-Signature. Requests whose timestamp falls outside Skew of the
+// server's clock are rejected, bounding replay of a cached WebView request.
+type HMACRequest struct {
+	Secret []byte
+	Skew   time.Duration // Allowed clock skew, defaults to defaultHMACSkew if zero
+}
+
+// Authenticate implements Authenticator.
+func (h HMACRequest) Authenticate(r *http.Request) error {
+	signature := r.Header.Get("X-Bridge-Signature")
+	if signature == "" {
+		return errors.New("ghostbridge: missing X-Bridge-Signature header")
+	}
+	timestamp := r.Header.Get("X-Bridge-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("ghostbridge: missing or invalid X-Bridge-Timestamp header")
+	}
+	skew := h.Skew
+	if skew <= 0 {
+		skew = defaultHMACSkew
+	}
+	if delta := time.Since(time.Unix(seconds, 0)); delta > skew || delta < -skew {
+		return errors.New("ghostbridge: timestamp outside of allowed skew")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write([]byte(r.Method))
+	mac.Write([]byte(r.URL.Path))
+	mac.Write(body)
+	mac.Write([]byte(timestamp))
+
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("ghostbridge: invalid signature")
+	}
+	return nil
+}
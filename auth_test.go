@@ -0,0 +1,109 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test that RotatingBearer accepts the active token, keeps a superseded one
+// valid until its grace window elapses, and rejects everything else.
+func TestRotatingBearer(t *testing.T) {
+	auth := NewRotatingBearer("old-token", 20*time.Millisecond)
+
+	authenticate := func(token string) error {
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return auth.Authenticate(req)
+	}
+
+	if err := authenticate("old-token"); err != nil {
+		t.Fatalf("Expected active token to authenticate, got: %v", err)
+	}
+	if err := authenticate("bogus-token"); err == nil {
+		t.Fatalf("Expected unknown token to be rejected")
+	}
+	if err := authenticate(""); err == nil {
+		t.Fatalf("Expected missing token to be rejected")
+	}
+
+	auth.Rotate("new-token")
+
+	if err := authenticate("new-token"); err != nil {
+		t.Fatalf("Expected newly rotated-in token to authenticate, got: %v", err)
+	}
+	if err := authenticate("old-token"); err != nil {
+		t.Fatalf("Expected superseded token to still authenticate within the grace window, got: %v", err)
+	}
+
+	// Sleep well past the grace window so ordinary scheduling jitter can't
+	// make this test flaky.
+	time.Sleep(300 * time.Millisecond)
+	if err := authenticate("old-token"); err == nil {
+		t.Fatalf("Expected superseded token to be rejected past its grace window")
+	}
+	if err := authenticate("new-token"); err != nil {
+		t.Fatalf("Expected active token to keep authenticating, got: %v", err)
+	}
+}
+
+// Test that HMACRequest accepts a correctly signed request and rejects a
+// tampered signature or a timestamp outside the allowed skew.
+func TestHMACRequest(t *testing.T) {
+	secret := []byte("shared-secret")
+	auth := HMACRequest{Secret: secret, Skew: time.Minute}
+
+	sign := func(method, path string, body []byte, timestamp int64) (string, string) {
+		ts := strconv.FormatInt(timestamp, 10)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(method))
+		mac.Write([]byte(path))
+		mac.Write(body)
+		mac.Write([]byte(ts))
+		return hex.EncodeToString(mac.Sum(nil)), ts
+	}
+
+	newRequest := func(body []byte, signature, timestamp string) *http.Request {
+		req := httptest.NewRequest("POST", "/bridge", bytes.NewReader(body))
+		req.Header.Set("X-Bridge-Signature", signature)
+		req.Header.Set("X-Bridge-Timestamp", timestamp)
+		return req
+	}
+
+	payload := []byte(`{"hello":"world"}`)
+
+	signature, ts := sign("POST", "/bridge", payload, time.Now().Unix())
+	req := newRequest(payload, signature, ts)
+	if err := auth.Authenticate(req); err != nil {
+		t.Fatalf("Expected correctly signed request to authenticate, got: %v", err)
+	}
+	// Authenticate must restore the body it consumed to compute the HMAC, so
+	// the wrapped handler can still read it.
+	if restored, err := ioutil.ReadAll(req.Body); err != nil || !bytes.Equal(restored, payload) {
+		t.Fatalf("Expected request body to be preserved after authentication, got %q, err: %v", restored, err)
+	}
+
+	if err := auth.Authenticate(newRequest([]byte(`{"hello":"tampered"}`), signature, ts)); err == nil {
+		t.Fatalf("Expected a request with a tampered body to be rejected")
+	}
+	if err := auth.Authenticate(newRequest(payload, signature+"00", ts)); err == nil {
+		t.Fatalf("Expected tampered signature to be rejected")
+	}
+
+	staleSignature, staleTS := sign("POST", "/bridge", payload, time.Now().Add(-time.Hour).Unix())
+	if err := auth.Authenticate(newRequest(payload, staleSignature, staleTS)); err == nil {
+		t.Fatalf("Expected timestamp outside the allowed skew to be rejected")
+	}
+}
@@ -0,0 +1,205 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// caAuthority is the local certificate authority backing a Bridge created
+// via New. React Native trusts its certificate once, and the bridge mints
+// leaf certificates signed by it on demand, one per logical origin the
+// WebView talks to, so multiple virtual hosts can share a single listener.
+type caAuthority struct {
+	certPEM string
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	serial uint64
+	leaves map[string]*leaf
+}
+
+// leaf is a cached, already issued leaf certificate.
+type leaf struct {
+	certPEM string
+	keyPEM  string
+	cert    tls.Certificate
+}
+
+// generateCA creates a fresh self-signed certificate authority: IsCA set and
+// KeyUsageCertSign granted, so it can sign leaf certificates.
+func generateCA() (*caAuthority, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Ghost Bridge Local CA"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(defaultLifetime),
+
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	blob, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(blob)
+	if err != nil {
+		return nil, err
+	}
+	return &caAuthority{
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: blob})),
+		cert:    cert,
+		key:     priv,
+		serial:  1,
+		leaves:  make(map[string]*leaf),
+	}, nil
+}
+
+// issue mints, or returns the cached, ECDSA leaf certificate for dnsNames,
+// signed by the CA and valid for ttl. A non-positive ttl defaults to
+// defaultLifetime instead of minting an already-expired certificate.
+func (ca *caAuthority) issue(dnsNames []string, ttl time.Duration) (certPEM, keyPEM string, err error) {
+	if ttl <= 0 {
+		ttl = defaultLifetime
+	}
+	key := leafCacheKey(dnsNames)
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cached, ok := ca.leaves[key]; ok {
+		return cached.certPEM, cached.keyPEM, nil
+	}
+
+	template := x509.Certificate{
+		SerialNumber: new(big.Int).SetUint64(ca.nextSerial()),
+		Subject: pkix.Name{
+			Organization: []string{"Ghost Bridge Leaf"},
+		},
+		DNSNames:  dnsNames,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(ttl),
+
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	pemCert, pemKey, tlsCert, err := ca.sign(&template)
+	if err != nil {
+		return "", "", err
+	}
+	ca.leaves[key] = &leaf{certPEM: pemCert, keyPEM: pemKey, cert: tlsCert}
+
+	return pemCert, pemKey, nil
+}
+
+// issueClient mints an ECDSA client certificate signed by the CA, for the
+// client side of a Bridge's mutual TLS mode (see NewMutual). Unlike issue,
+// client certificates aren't cached by SAN set since NewMutual only ever
+// mints one per bridge.
+func (ca *caAuthority) issueClient() (certPEM, keyPEM string, err error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	template := x509.Certificate{
+		SerialNumber: new(big.Int).SetUint64(ca.nextSerial()),
+		Subject: pkix.Name{
+			Organization: []string{"Ghost Bridge Client"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(defaultLifetime),
+
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	pemCert, pemKey, _, err := ca.sign(&template)
+	return pemCert, pemKey, err
+}
+
+// nextSerial returns the next monotonically increasing serial number, well
+// within the 20-byte serial cap. Callers must hold ca.mu.
+func (ca *caAuthority) nextSerial() uint64 {
+	ca.serial++
+	return ca.serial
+}
+
+// sign generates a fresh ECDSA keypair and signs template with the CA's key,
+// returning the PEM encoded cert/key and their parsed tls.Certificate form.
+// Callers must hold ca.mu.
+func (ca *caAuthority) sign(template *x509.Certificate) (certPEM, keyPEM string, cert tls.Certificate, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", tls.Certificate{}, err
+	}
+	keyBlob, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return "", "", tls.Certificate{}, err
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBlob})
+
+	blob, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &priv.PublicKey, ca.key)
+	if err != nil {
+		return "", "", tls.Certificate{}, err
+	}
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: blob})
+
+	tlsCert, err := tls.X509KeyPair(pemCert, pemKey)
+	if err != nil {
+		return "", "", tls.Certificate{}, err
+	}
+	return string(pemCert), string(pemKey), tlsCert, nil
+}
+
+// getCertificate SNI-dispatches an already issued leaf certificate, falling
+// back to the default "localhost" leaf when the client didn't send SNI or
+// asked for a host no leaf has been issued for yet.
+func (ca *caAuthority) getCertificate(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if info != nil && info.ServerName != "" {
+		if l, ok := ca.leaves[leafCacheKey([]string{info.ServerName})]; ok {
+			return &l.cert, nil
+		}
+		// Leaves issued for several names are cached under their whole SAN
+		// set, so fall back to matching the host against each leaf's names.
+		for _, l := range ca.leaves {
+			parsed, err := x509.ParseCertificate(l.cert.Certificate[0])
+			if err == nil && parsed.VerifyHostname(info.ServerName) == nil {
+				return &l.cert, nil
+			}
+		}
+	}
+	return &ca.leaves[leafCacheKey([]string{"localhost"})].cert, nil
+}
+
+// leafCacheKey derives a stable lookup key for a SAN set: the hex SHA1 over
+// the sorted, newline-joined DNS names, so repeat issue calls for the same
+// hosts hit the cache instead of minting a new certificate each time.
+func leafCacheKey(dnsNames []string) string {
+	sorted := append([]string(nil), dnsNames...)
+	sort.Strings(sorted)
+	sum := sha1.Sum([]byte(strings.Join(sorted, "\n")))
+	return fmt.Sprintf("%x", sum)
+}
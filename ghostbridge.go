@@ -13,25 +13,59 @@ import (
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"io"
 	"math/big"
 	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 )
 
-// Bridge is an HTTPS server that bridges Go and React Native in a secure way,
-// providing an encrypted and mutually authenticated data pathway.
-type Bridge struct {
-	token       string       // Client authorization token to access the HTTPS bridge
-	listener    net.Listener // TCP listener accepting the HTTPS connections from React Native
-	certificate string       // TLS certificate proving the server's authenticity
+// defaultLifetime is the validity duration used for generated certificates
+// when the caller does not request a specific one.
+const defaultLifetime = time.Hour * 24 * 365
+
+// Identity bundles the cryptographic material that uniquely identifies a
+// Bridge instance: the self-signed certificate used for TLS, the private key
+// backing it and the bearer token gating access to the bridge. Callers may
+// persist an Identity (e.g. in the platform Keychain/Keystore) and hand it
+// back into NewWithIdentity on the next process start to avoid the React
+// Native WebView having to re-import a new certificate every time.
+type Identity struct {
+	Cert  string // PEM encoded TLS certificate
+	Key   string // PEM encoded EC private key
+	Token string // Client authorization token to access the HTTPS bridge
 }
 
-// New create a new secure web bridge into a Go HTTP server with an authentication
-// wrapper built around it, ensuring mobile app security.
-func New(handler http.Handler) (*Bridge, error) {
-	// Generate a private key for the certificate
+// valid reports whether the identity is complete and its certificate has not
+// yet expired. An empty or malformed identity is always considered invalid.
+func (id *Identity) valid() bool {
+	if id == nil || id.Cert == "" || id.Key == "" || id.Token == "" {
+		return false
+	}
+	block, _ := pem.Decode([]byte(id.Cert))
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(cert.NotAfter)
+}
+
+// generateIdentity creates a fresh ECDSA keypair, a self-signed TLS
+// certificate valid for defaultLifetime and a random bearer token.
+func generateIdentity() (*Identity, error) {
+	return generateIdentityWithLifetime(defaultLifetime)
+}
+
+// generateIdentityWithLifetime is like generateIdentity, but allows
+// customizing the validity duration of the generated certificate, so the
+// rotation subsystem can mint short- or long-lived successors.
+func generateIdentityWithLifetime(lifetime time.Duration) (*Identity, error) {
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, err
@@ -50,7 +84,7 @@ func New(handler http.Handler) (*Bridge, error) {
 		},
 		DNSNames:  []string{"localhost"},
 		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(time.Hour * 24 * 365),
+		NotAfter:  time.Now().Add(lifetime),
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
@@ -62,53 +96,226 @@ func New(handler http.Handler) (*Bridge, error) {
 	}
 	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: blob})
 
-	// Load the certificate and start an HTTPS server with it
-	cert, err := tls.X509KeyPair(pemCert, pemPriv)
+	token, err := generateToken()
 	if err != nil {
 		return nil, err
 	}
-	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	return &Identity{Cert: string(pemCert), Key: string(pemPriv), Token: token}, nil
+}
+
+// generateToken creates a random, base64 encoded bearer token used to
+// authorize access to the bridge.
+func generateToken() (string, error) {
+	blob := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, blob); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// Bridge is an HTTPS server that bridges Go and React Native in a secure way,
+// providing an encrypted and mutually authenticated data pathway.
+type Bridge struct {
+	mu       sync.RWMutex     // Guards identity and current against concurrent rotation
+	identity Identity         // Cryptographic identity of the bridge (cert, key, token)
+	current  *tls.Certificate // Parsed form of identity's cert/key, served via GetCertificate
+	ca       *caAuthority     // Local certificate authority, set on bridges created via New
+
+	listener   net.Listener  // Listener accepting the HTTPS connections from React Native (TCP or unix)
+	rotate     chan struct{} // Closed on Close to stop the rotation goroutine, nil if rotation is disabled
+	socketPath string        // Filesystem path of the unix socket, set on bridges created via NewUnix
+
+	clientCert string // PEM encoded client certificate, set when running in mutual TLS mode
+	clientKey  string // PEM encoded client private key, set when running in mutual TLS mode
+}
+
+// New create a new secure web bridge into a Go HTTP server with an authentication
+// wrapper built around it, ensuring mobile app security.
+//
+// The bridge is backed by a local certificate authority (see CA) rather than
+// a single leaf certificate: this lets apps that talk to several logical
+// origins through the bridge mint one leaf per origin via IssueLeaf, while
+// React Native only ever has to trust the CA once. Because of that, Identity
+// returns nil for bridges created via New; use NewWithIdentity instead if
+// the persisted-identity workflow is required.
+func New(handler http.Handler) (*Bridge, error) {
+	ca, err := generateCA()
 	if err != nil {
 		return nil, err
 	}
-	// Create the verification middleware to authorize the client
-	blob = make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, blob); err != nil {
+	if _, _, err := ca.issue([]string{"localhost"}, defaultLifetime); err != nil {
+		return nil, err
+	}
+	token, err := generateToken()
+	if err != nil {
 		return nil, err
 	}
-	token := base64.StdEncoding.EncodeToString(blob)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: ca.getCertificate})
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, authHandler(StaticBearer{Token: token}, handler))
+
+	return &Bridge{
+		identity: Identity{Token: token},
+		listener: listener,
+		ca:       ca,
+	}, nil
+}
 
-	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != "Bearer "+token {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
+// NewWithIdentity is like New, but instead of always generating a fresh
+// keypair, certificate and bearer token, it attempts to reuse the identity
+// supplied by the caller. This lets an application persist its identity
+// across restarts so the React Native WebView never has to re-import a new
+// certificate and re-prompt trust.
+//
+// If identity is nil, or its certificate has expired, a fresh identity is
+// generated instead; otherwise the supplied identity is reused verbatim.
+func NewWithIdentity(handler http.Handler, identity *Identity) (*Bridge, error) {
+	if !identity.valid() {
+		fresh, err := generateIdentity()
+		if err != nil {
+			return nil, err
 		}
-		handler.ServeHTTP(w, r)
-	}))
+		identity = fresh
+	}
+	// Load the certificate and start an HTTPS server with it
+	cert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	// Create the verification middleware to authorize the client
+	go http.Serve(listener, authHandler(StaticBearer{Token: identity.Token}, handler))
 
 	return &Bridge{
-		token:       token,
-		listener:    listener,
-		certificate: string(pemCert),
+		identity: *identity,
+		listener: listener,
 	}, nil
 }
 
-// Close terminates the underlying listener, and implicitly the bridge.
+// Close terminates the underlying listener, and implicitly the bridge. If
+// the bridge was created with rotation enabled, the rotation goroutine is
+// also stopped.
 func (b *Bridge) Close() error {
-	return b.listener.Close()
+	if b.rotate != nil {
+		close(b.rotate)
+	}
+	err := b.listener.Close()
+	if b.socketPath != "" {
+		os.Remove(b.socketPath)
+	}
+	return err
 }
 
-// Port returns the listener port assigned to the bridge.
+// Port returns the listener port assigned to the bridge, or -1 for bridges
+// created via NewUnix, which have no TCP port to report (see SocketPath).
 func (b *Bridge) Port() int {
+	if b.socketPath != "" {
+		return -1
+	}
 	return b.listener.Addr().(*net.TCPAddr).Port
 }
 
-// Cert returns the TLS certificate assigned to the bridge.
+// SocketPath returns the filesystem path of the unix domain socket backing
+// the bridge, for bridges created via NewUnix. Other constructors return an
+// empty string.
+func (b *Bridge) SocketPath() string {
+	return b.socketPath
+}
+
+// Cert returns the certificate React Native should trust to talk to the
+// bridge. For bridges created with NewWithOptions, this reflects the most
+// recently rotated-in certificate. For bridges created with New, it is the
+// bridge's local certificate authority (see CA), since the actual leaf
+// served over TLS may vary per origin.
 func (b *Bridge) Cert() string {
-	return b.certificate
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.ca != nil {
+		return b.ca.certPEM
+	}
+	return b.identity.Cert
+}
+
+// CA returns the PEM encoded certificate of the bridge's local certificate
+// authority, set on bridges created via New. React Native trusts it once;
+// every leaf certificate minted by IssueLeaf, as well as the bridge's
+// default "localhost" certificate, chains up to it. Bridges created through
+// any other constructor return an empty string.
+func (b *Bridge) CA() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.ca == nil {
+		return ""
+	}
+	return b.ca.certPEM
+}
+
+// IssueLeaf mints an ECDSA leaf certificate for dnsNames, signed by the
+// bridge's local certificate authority and valid for ttl; a non-positive ttl
+// defaults to defaultLifetime. Repeat calls for the same set of DNS names
+// return the same cached certificate instead of minting a new one. Once
+// issued, the leaf is immediately servable: the TLS
+// listener's GetCertificate dispatches by SNI through the same cache, so new
+// virtual hosts can be added at runtime without restarting the bridge.
+//
+// IssueLeaf only works on bridges created via New, which are the ones backed
+// by a local certificate authority.
+func (b *Bridge) IssueLeaf(dnsNames []string, ttl time.Duration) (certPEM, keyPEM string, err error) {
+	if b.ca == nil {
+		return "", "", errors.New("ghostbridge: bridge has no local certificate authority")
+	}
+	return b.ca.issue(dnsNames, ttl)
 }
 
 // Token returns the client authorization token to access the bridge.
 func (b *Bridge) Token() string {
-	return b.token
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.identity.Token
+}
+
+// Identity returns the cryptographic identity of the bridge (certificate,
+// private key and bearer token), so the caller can persist it and restore it
+// on the next application launch via NewWithIdentity.
+//
+// Bridges created via New are backed by a local certificate authority rather
+// than a single persistable leaf (see CA and IssueLeaf), so there is no
+// cert/key to hand back into NewWithIdentity; Identity returns nil for them
+// instead of a half-populated Identity. Use NewWithIdentity (or any other
+// non-CA constructor) when persisted restoration across restarts is needed.
+func (b *Bridge) Identity() *Identity {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.ca != nil {
+		return nil
+	}
+	identity := b.identity
+	return &identity
+}
+
+// getCertificate serves the bridge's current TLS certificate, re-read under
+// lock on every handshake so an in-progress rotation is picked up by new
+// connections without disturbing already-established ones.
+func (b *Bridge) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.current, nil
+}
+
+// ClientCert returns the PEM encoded client certificate React Native should
+// present for mutual TLS authentication. It is only set on bridges created
+// via NewMutual.
+func (b *Bridge) ClientCert() string {
+	return b.clientCert
+}
+
+// ClientKey returns the PEM encoded private key backing ClientCert. It is
+// only set on bridges created via NewMutual.
+func (b *Bridge) ClientKey() string {
+	return b.clientKey
 }
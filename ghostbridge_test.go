@@ -4,11 +4,15 @@
 package ghostbridge
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"testing"
+	"time"
 )
 
 // Test that a new, self-signed TLS certificate can be generated and an HTTPS
@@ -51,3 +55,281 @@ func TestBridge(t *testing.T) {
 	}
 	res.Body.Close()
 }
+
+// Test that a mutual TLS bridge authorizes a client presenting the issued
+// client certificate, without any bearer token.
+func TestBridgeMutual(t *testing.T) {
+	bridge, err := NewMutual(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Yay, it works!"))
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create mutual TLS bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	// Trust the bridge's server certificate and present the issued client one
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(bridge.Cert())) {
+		t.Fatalf("Failed to load server certificate")
+	}
+	clientCert, err := tls.X509KeyPair([]byte(bridge.ClientCert()), []byte(bridge.ClientKey()))
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: []tls.Certificate{clientCert},
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://localhost:%d", bridge.Port()), nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %v", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to execute HTTP request: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Invalid status returned from bridge: have %v, want %v", res.StatusCode, http.StatusOK)
+	}
+	res.Body.Close()
+
+	// A client without the certificate should be rejected at the TLS layer
+	plain := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	if _, err := plain.Get(fmt.Sprintf("https://localhost:%d", bridge.Port())); err == nil {
+		t.Fatalf("Expected request without client certificate to fail")
+	}
+}
+
+// Test that a bridge created with a short renewal window rotates in a new
+// certificate in the background, and that the rotated-in certificate is
+// immediately usable for new TLS handshakes.
+func TestBridgeRotation(t *testing.T) {
+	rotated := make(chan string, 1)
+
+	bridge, err := NewWithOptions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Yay, it works!"))
+	}), Options{
+		// Lifetime minus RenewBefore is also the gap between consecutive
+		// rotations, so it's kept generous (3s) to leave enough headroom
+		// for the assertions below to run before the bridge rotates again.
+		Lifetime:    5 * time.Second,
+		RenewBefore: 2 * time.Second,
+		OnRotate: func(newCert, newKey string) {
+			select {
+			case rotated <- newCert:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create rotating TLS bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	original := bridge.Cert()
+
+	var rotatedCert string
+	select {
+	case rotatedCert = <-rotated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for certificate rotation")
+	}
+	if rotatedCert == original {
+		t.Fatalf("Certificate did not change after rotation")
+	}
+
+	// The rotated-in certificate should be servable straight away
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(rotatedCert)) {
+		t.Fatalf("Failed to load rotated server certificate")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://localhost:%d", bridge.Port()), nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bridge.Token())
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to execute HTTP request after rotation: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Invalid status returned from bridge: have %v, want %v", res.StatusCode, http.StatusOK)
+	}
+	res.Body.Close()
+}
+
+// Test that a bridge created via New can mint leaf certificates on demand
+// for additional virtual hosts, and that the TLS listener SNI-dispatches to
+// the right leaf for each one without needing a restart.
+func TestBridgeIssueLeaf(t *testing.T) {
+	bridge, err := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Yay, it works!"))
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create CA-backed TLS bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	if _, _, err := bridge.IssueLeaf([]string{"foo.example"}, time.Hour); err != nil {
+		t.Fatalf("Failed to issue leaf certificate: %v", err)
+	}
+
+	// React Native only ever has to trust the CA, not the individual leaves
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(bridge.CA())) {
+		t.Fatalf("Failed to load CA certificate")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    roots,
+				ServerName: "foo.example",
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://localhost:%d", bridge.Port()), nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bridge.Token())
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to execute HTTP request against the issued leaf's SNI name: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Invalid status returned from bridge: have %v, want %v", res.StatusCode, http.StatusOK)
+	}
+	res.Body.Close()
+}
+
+// Test that bridges backed by a local certificate authority (created via
+// New) don't expose a persistable Identity, since there is no single
+// cert/key to hand back into NewWithIdentity.
+func TestBridgeCAIdentity(t *testing.T) {
+	bridge, err := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatalf("Failed to create CA-backed TLS bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	if identity := bridge.Identity(); identity != nil {
+		t.Fatalf("Expected nil identity for a CA-backed bridge, got %+v", identity)
+	}
+}
+
+// Test that NewWithIdentity reuses a valid identity verbatim, and falls back
+// to generating a fresh one when handed nil or an expired certificate.
+func TestBridgeNewWithIdentity(t *testing.T) {
+	first, err := NewWithIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil)
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer first.Close()
+
+	identity := first.Identity()
+	if identity == nil {
+		t.Fatalf("Expected a non-nil identity from a non-CA-backed bridge")
+	}
+
+	second, err := NewWithIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), identity)
+	if err != nil {
+		t.Fatalf("Failed to create bridge from a persisted identity: %v", err)
+	}
+	defer second.Close()
+
+	reused := second.Identity()
+	if reused.Cert != identity.Cert || reused.Key != identity.Key || reused.Token != identity.Token {
+		t.Fatalf("Expected identity to be reused verbatim, have %+v, want %+v", reused, identity)
+	}
+
+	// An expired identity must not be reused: a fresh one is generated instead.
+	expiredIdentity, err := generateIdentityWithLifetime(-time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate expired identity: %v", err)
+	}
+	third, err := NewWithIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), expiredIdentity)
+	if err != nil {
+		t.Fatalf("Failed to create bridge from an expired identity: %v", err)
+	}
+	defer third.Close()
+
+	if fresh := third.Identity(); fresh.Cert == expiredIdentity.Cert {
+		t.Fatalf("Expected a fresh identity to be generated in place of an expired one")
+	}
+
+	// A nil identity must also fall back to generating a fresh one.
+	fourth, err := NewWithIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil)
+	if err != nil {
+		t.Fatalf("Failed to create bridge from a nil identity: %v", err)
+	}
+	defer fourth.Close()
+
+	if identity := fourth.Identity(); identity == nil || !identity.valid() {
+		t.Fatalf("Expected a valid, freshly generated identity, got %+v", identity)
+	}
+}
+
+// Test that a bridge created via NewUnix serves over a private, 0600 unix
+// domain socket instead of a TCP port.
+func TestBridgeUnix(t *testing.T) {
+	bridge, err := NewUnix(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Yay, it works!"))
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create unix socket TLS bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	if bridge.Port() != -1 {
+		t.Fatalf("Invalid port returned for a unix socket bridge: have %v, want -1", bridge.Port())
+	}
+	info, err := os.Stat(bridge.SocketPath())
+	if err != nil {
+		t.Fatalf("Failed to stat unix socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("Invalid unix socket permissions: have %o, want %o", perm, 0600)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(bridge.Cert())) {
+		t.Fatalf("Failed to load server certificate")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", bridge.SocketPath())
+			},
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	req, err := http.NewRequest("GET", "https://localhost", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bridge.Token())
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to execute HTTP request: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Invalid status returned from bridge: have %v, want %v", res.StatusCode, http.StatusOK)
+	}
+	res.Body.Close()
+}
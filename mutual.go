@@ -0,0 +1,60 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+)
+
+// NewMutual is like New, but instead of authorizing clients with a bearer
+// token travelling in the Authorization header (readable by any process
+// able to attach to the JS bridge), it requires the client to present a TLS
+// certificate signed by a freshly generated, bridge-private client CA (the
+// same caAuthority backing New's server-side leaf issuance, here minting a
+// single client-auth leaf instead of server leaves). The issued client
+// certificate and key are exposed via ClientCert and ClientKey so React
+// Native can embed them into its fetch implementation. Possession of the TLS
+// session backed by that certificate is itself proof of authorization, so
+// the bearer check is skipped entirely in this mode.
+func NewMutual(handler http.Handler) (*Bridge, error) {
+	identity, err := generateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+	if err != nil {
+		return nil, err
+	}
+	clientCA, err := generateCA()
+	if err != nil {
+		return nil, err
+	}
+	clientCert, clientKey, err := clientCA.issueClient()
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(clientCA.certPEM)) {
+		return nil, errors.New("ghostbridge: failed to parse generated client CA certificate")
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, handler)
+
+	return &Bridge{
+		identity:   *identity,
+		listener:   listener,
+		clientCert: clientCert,
+		clientKey:  clientKey,
+	}, nil
+}
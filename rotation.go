@@ -0,0 +1,127 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RotationCallback is invoked after the bridge has rotated to a new
+// certificate, with its PEM encoded cert and key, so the caller can push the
+// new trust anchor to the RN WebView (e.g. via the Java/ObjC native layer).
+type RotationCallback func(newCert, newKey string)
+
+// Options customizes the lifecycle of a Bridge created via NewWithOptions.
+type Options struct {
+	// Lifetime is the validity duration of generated certificates. Defaults
+	// to defaultLifetime (365 days) if zero.
+	Lifetime time.Duration
+	// RenewBefore is how long before a certificate's expiry the bridge
+	// rotates in a new one. Defaults to a third of Lifetime if zero. The wait
+	// until that point is jittered by up to 10% to avoid many devices
+	// renewing in lockstep.
+	RenewBefore time.Duration
+	// OnRotate, if set, is invoked after each rotation.
+	OnRotate RotationCallback
+}
+
+// NewWithOptions is like New, but allows customizing certificate lifetime
+// and enables a background rotation subsystem: some configurable time
+// before the current certificate expires, a new keypair and certificate are
+// generated and atomically swapped in via tls.Config.GetCertificate.
+// In-flight connections keep using the old certificate; only new handshakes
+// pick up the rotated one.
+func NewWithOptions(handler http.Handler, opts Options) (*Bridge, error) {
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = defaultLifetime
+	}
+	if opts.RenewBefore <= 0 {
+		opts.RenewBefore = opts.Lifetime / 3
+	}
+	identity, err := generateIdentityWithLifetime(opts.Lifetime)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bridge{identity: *identity, current: &cert, rotate: make(chan struct{})}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: b.getCertificate})
+	if err != nil {
+		return nil, err
+	}
+	b.listener = listener
+
+	go http.Serve(listener, authHandler(StaticBearer{Token: identity.Token}, handler))
+	go b.rotator(opts)
+
+	return b, nil
+}
+
+// rotator waits until the current certificate is within opts.RenewBefore of
+// expiring (jittered), then generates and swaps in a replacement, repeating
+// until the bridge is closed.
+func (b *Bridge) rotator(opts Options) {
+	for {
+		b.mu.RLock()
+		certPEM := b.identity.Cert
+		b.mu.RUnlock()
+
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			return
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return
+		}
+		wait := jitter(time.Until(cert.NotAfter.Add(-opts.RenewBefore)))
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-time.After(wait):
+		case <-b.rotate:
+			return
+		}
+
+		identity, err := generateIdentityWithLifetime(opts.Lifetime)
+		if err != nil {
+			continue
+		}
+		identity.Token = b.Token() // keep the existing bearer token across rotations
+		newCert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+		if err != nil {
+			continue
+		}
+
+		b.mu.Lock()
+		b.identity.Cert = identity.Cert
+		b.identity.Key = identity.Key
+		b.current = &newCert
+		b.mu.Unlock()
+
+		if opts.OnRotate != nil {
+			opts.OnRotate(identity.Cert, identity.Key)
+		}
+	}
+}
+
+// jitter randomizes d by up to +/-10%, so many bridges provisioned at the
+// same time don't all renew their certificates in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	spread := d / 10
+	return d - spread + time.Duration(rand.Int63n(int64(2*spread+1)))
+}
@@ -0,0 +1,82 @@
+// go-ghostbridge - React Native to Go bridge
+// Copyright (c) 2019 Péter Szilágyi. All rights reserved.
+
+package ghostbridge
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask-swap-and-listen window in NewUnix, since
+// syscall.Umask is process-global: without it, two concurrent NewUnix calls
+// could restore each other's original umask before their own net.Listen
+// actually created the socket file, silently widening its permissions. Only
+// NewUnix takes it; other code creating files while the window is open is
+// not serialized and is subject to the restricted umask.
+var umaskMu sync.Mutex
+
+// NewUnix is like New, but instead of listening on TCP loopback (observable
+// by every process on the device, and on Android by any app holding the
+// INTERNET permission), it binds a unix domain socket inside the OS temp
+// directory, which on a properly sandboxed mobile app resolves to the app's
+// private data dir. The socket is created with 0600 permissions and is still
+// wrapped in TLS plus the bearer check for defense-in-depth. The RN side
+// should be pointed at the path returned by Bridge.SocketPath via a small
+// okhttp/NSURLSession adapter; Port returns -1 since there is no TCP port.
+//
+// Use New for the simulator/dev loopback transport and NewUnix for on-device
+// production builds; callers are expected to pick the right one per platform.
+func NewUnix(handler http.Handler) (*Bridge, error) {
+	identity, err := generateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(identity.Cert), []byte(identity.Key))
+	if err != nil {
+		return nil, err
+	}
+	path, err := generateSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	// Restrict the umask around the bind so the socket is created with 0600
+	// permissions atomically, rather than world/group-accessible for the
+	// brief window between Listen and a chmod done after the fact. umaskMu
+	// keeps this window from interleaving with another NewUnix call's.
+	umaskMu.Lock()
+	mask := syscall.Umask(0177)
+	unixListener, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	umaskMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	listener := tls.NewListener(unixListener, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	go http.Serve(listener, authHandler(StaticBearer{Token: identity.Token}, handler))
+
+	return &Bridge{
+		identity:   *identity,
+		listener:   listener,
+		socketPath: path,
+	}, nil
+}
+
+// generateSocketPath picks a random, unused path for the bridge's unix
+// socket inside the OS temp directory.
+func generateSocketPath() (string, error) {
+	blob := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, blob); err != nil {
+		return "", err
+	}
+	return filepath.Join(os.TempDir(), "ghostbridge-"+hex.EncodeToString(blob)+".sock"), nil
+}